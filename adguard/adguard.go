@@ -0,0 +1,89 @@
+// Package adguard implements the RuleStore backends backed by AdGuard Home:
+// a Client for the user filtering rules list, and a RewritesClient for the
+// DNS rewrites API.
+package adguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Client manages AdGuard Home's user filtering rules
+// (/control/filtering/status, /control/filtering/set_rules). Each rule is a
+// raw AdGuard filter syntax line.
+type Client struct {
+	conn *conn
+}
+
+type filteringStatus struct {
+	UserRules []string `json:"user_rules"`
+}
+
+// NewClient returns a filtering-rules Client for the AdGuard Home instance
+// at baseURL, authenticating as configured by auth. If httpClient is nil, a
+// client with a 10 second timeout is used.
+func NewClient(baseURL string, auth Auth, httpClient *http.Client) *Client {
+	return &Client{conn: newConn(baseURL, auth, httpClient)}
+}
+
+// Name identifies this backend for logging and the BACKEND config value.
+func (c *Client) Name() string {
+	return "adguard"
+}
+
+// Authoritative is false: the sidecar shares the filtering rules list with
+// other writers, so reconciliation must preserve unmanaged user rules.
+func (c *Client) Authoritative() bool {
+	return false
+}
+
+// List returns the current user filtering rules, in their existing order.
+func (c *Client) List(ctx context.Context) ([]string, error) {
+	resp, err := c.conn.request(ctx, "GET", "/control/filtering/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var status filteringStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+
+	return status.UserRules, nil
+}
+
+// Replace overwrites the entire user filtering rules list with rules.
+func (c *Client) Replace(ctx context.Context, rules []string) error {
+	payload := struct {
+		Rules []string `json:"rules"`
+	}{
+		Rules: rules,
+	}
+
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rules: %w", err)
+	}
+
+	resp, err := c.conn.request(ctx, "POST", "/control/filtering/set_rules", jsonBody)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package adguard
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClient_Replace_ContentTypeHeader(t *testing.T) {
+	var receivedContentType string
+	var receivedBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedContentType = r.Header.Get("Content-Type")
+		receivedBody, _ = io.ReadAll(r.Body)
+
+		if r.URL.Path == "/control/filtering/set_rules" {
+			if receivedContentType != "application/json" {
+				w.WriteHeader(415)
+				w.Write([]byte("only content-type application/json is allowed"))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, Auth{Mode: AuthBasic, User: "testuser", Pass: "testpass"}, nil)
+
+	rules := []string{"rule1", "rule2", "rule3"}
+	if err := client.Replace(context.Background(), rules); err != nil {
+		t.Errorf("Replace() returned error: %v", err)
+	}
+
+	if receivedContentType != "application/json" {
+		t.Errorf("Content-Type header = %q, want \"application/json\"", receivedContentType)
+	}
+
+	var payload struct {
+		Rules []string `json:"rules"`
+	}
+	if err := json.Unmarshal(receivedBody, &payload); err != nil {
+		t.Errorf("Failed to unmarshal request body: %v", err)
+	}
+	if !reflect.DeepEqual(payload.Rules, rules) {
+		t.Errorf("Request body rules = %v, want %v", payload.Rules, rules)
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(filteringStatus{UserRules: []string{"rule1", "rule2"}})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, Auth{Mode: AuthBasic, User: "testuser", Pass: "testpass"}, nil)
+
+	rules, err := client.List(context.Background())
+	if err != nil {
+		t.Fatalf("List() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(rules, []string{"rule1", "rule2"}) {
+		t.Errorf("List() = %v, want [rule1 rule2]", rules)
+	}
+}
+
+func TestParseRewriteRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		want    rewriteEntry
+		wantErr bool
+	}{
+		{
+			name: "valid rule",
+			rule: "svc.example.com 10.0.0.5",
+			want: rewriteEntry{Domain: "svc.example.com", Answer: "10.0.0.5"},
+		},
+		{
+			name:    "missing answer",
+			rule:    "svc.example.com",
+			wantErr: true,
+		},
+		{
+			name:    "empty rule",
+			rule:    "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRewriteRule(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRewriteRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseRewriteRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewritesClient_Replace(t *testing.T) {
+	var adds, deletes []rewriteEntry
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/control/rewrite/list":
+			json.NewEncoder(w).Encode([]rewriteEntry{
+				{Domain: "stale.example.com", Answer: "10.0.0.1"},
+				{Domain: "keep.example.com", Answer: "10.0.0.2"},
+			})
+		case "/control/rewrite/add":
+			var e rewriteEntry
+			json.NewDecoder(r.Body).Decode(&e)
+			adds = append(adds, e)
+			w.WriteHeader(http.StatusOK)
+		case "/control/rewrite/delete":
+			var e rewriteEntry
+			json.NewDecoder(r.Body).Decode(&e)
+			deletes = append(deletes, e)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	client := NewRewritesClient(server.URL, Auth{Mode: AuthBasic, User: "testuser", Pass: "testpass"}, nil)
+
+	err := client.Replace(context.Background(), []string{
+		"keep.example.com 10.0.0.2",
+		"new.example.com 10.0.0.3",
+	})
+	if err != nil {
+		t.Fatalf("Replace() returned error: %v", err)
+	}
+
+	if !reflect.DeepEqual(deletes, []rewriteEntry{{Domain: "stale.example.com", Answer: "10.0.0.1"}}) {
+		t.Errorf("deletes = %v, want [stale.example.com 10.0.0.1]", deletes)
+	}
+	if !reflect.DeepEqual(adds, []rewriteEntry{{Domain: "new.example.com", Answer: "10.0.0.3"}}) {
+		t.Errorf("adds = %v, want [new.example.com 10.0.0.3]", adds)
+	}
+}
@@ -0,0 +1,157 @@
+package adguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RewritesClient manages AdGuard Home's DNS rewrites
+// (/control/rewrite/list, /add, /delete). This is the primitive
+// external-dns-style A/AAAA/CNAME publishing actually maps to, as opposed to
+// filter rules.
+//
+// Each rule is encoded as "<domain> <answer>", e.g. "svc.example.com
+// 10.0.0.5". Replace diffs the desired set against the current one and
+// issues individual add/delete calls, since the rewrites API has no bulk
+// "set" endpoint.
+type RewritesClient struct {
+	conn *conn
+}
+
+type rewriteEntry struct {
+	Domain string `json:"domain"`
+	Answer string `json:"answer"`
+}
+
+// NewRewritesClient returns a DNS rewrites Client for the AdGuard Home
+// instance at baseURL, authenticating as configured by auth. If httpClient
+// is nil, a client with a 10 second timeout is used.
+func NewRewritesClient(baseURL string, auth Auth, httpClient *http.Client) *RewritesClient {
+	return &RewritesClient{conn: newConn(baseURL, auth, httpClient)}
+}
+
+// Name identifies this backend for logging and the BACKEND config value.
+func (c *RewritesClient) Name() string {
+	return "adguard-rewrites"
+}
+
+// Authoritative is true: rewrites are a dedicated record set the sidecar
+// fully owns, so reconciliation sets it to exactly the configured
+// ManagedRules rather than merging with whatever is already there.
+func (c *RewritesClient) Authoritative() bool {
+	return true
+}
+
+// List returns the current rewrites, each encoded as "<domain> <answer>".
+func (c *RewritesClient) List(ctx context.Context) ([]string, error) {
+	entries, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]string, len(entries))
+	for i, e := range entries {
+		rules[i] = fmt.Sprintf("%s %s", e.Domain, e.Answer)
+	}
+	return rules, nil
+}
+
+// Replace reconciles the rewrites list to match rules, each encoded as
+// "<domain> <answer>", by adding missing entries and deleting stale ones.
+func (c *RewritesClient) Replace(ctx context.Context, rules []string) error {
+	current, err := c.list(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list current rewrites: %w", err)
+	}
+
+	desired := make(map[rewriteEntry]bool, len(rules))
+	for _, rule := range rules {
+		entry, err := parseRewriteRule(rule)
+		if err != nil {
+			return err
+		}
+		desired[entry] = true
+	}
+
+	existing := make(map[rewriteEntry]bool, len(current))
+	for _, e := range current {
+		existing[e] = true
+	}
+
+	for _, e := range current {
+		if !desired[e] {
+			if err := c.delete(ctx, e); err != nil {
+				return fmt.Errorf("failed to delete rewrite %s -> %s: %w", e.Domain, e.Answer, err)
+			}
+		}
+	}
+	for entry := range desired {
+		if !existing[entry] {
+			if err := c.add(ctx, entry); err != nil {
+				return fmt.Errorf("failed to add rewrite %s -> %s: %w", entry.Domain, entry.Answer, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseRewriteRule parses a "<domain> <answer>" encoded rule.
+func parseRewriteRule(rule string) (rewriteEntry, error) {
+	domain, answer, found := strings.Cut(rule, " ")
+	if !found || domain == "" || answer == "" {
+		return rewriteEntry{}, fmt.Errorf("invalid rewrite rule %q: want \"<domain> <answer>\"", rule)
+	}
+	return rewriteEntry{Domain: domain, Answer: answer}, nil
+}
+
+func (c *RewritesClient) list(ctx context.Context) ([]rewriteEntry, error) {
+	resp, err := c.conn.request(ctx, "GET", "/control/rewrite/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	var entries []rewriteEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *RewritesClient) add(ctx context.Context, entry rewriteEntry) error {
+	return c.post(ctx, "/control/rewrite/add", entry)
+}
+
+func (c *RewritesClient) delete(ctx context.Context, entry rewriteEntry) error {
+	return c.post(ctx, "/control/rewrite/delete", entry)
+}
+
+func (c *RewritesClient) post(ctx context.Context, path string, entry rewriteEntry) error {
+	jsonBody, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rewrite entry: %w", err)
+	}
+
+	resp, err := c.conn.request(ctx, "POST", path, jsonBody)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package adguard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthMode selects how conn authenticates requests against AdGuard Home.
+type AuthMode string
+
+const (
+	// AuthBasic sends HTTP Basic auth on every request. The default.
+	AuthBasic AuthMode = "basic"
+	// AuthBearer sends an "Authorization: Bearer <token>" header.
+	AuthBearer AuthMode = "bearer"
+	// AuthSession performs POST /control/login once, caches the resulting
+	// session cookie, and re-authenticates on 401/403.
+	AuthSession AuthMode = "session"
+)
+
+// Auth holds the credentials for whichever AuthMode is selected.
+type Auth struct {
+	Mode        AuthMode
+	User        string
+	Pass        string
+	BearerToken string
+}
+
+// conn is the shared HTTP plumbing behind Client and RewritesClient: it
+// owns the base URL, auth mode, and (for AuthSession) the cached session
+// cookie, so both backends authenticate identically.
+type conn struct {
+	baseURL    string
+	auth       Auth
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	cookie *http.Cookie
+}
+
+func newConn(baseURL string, auth Auth, httpClient *http.Client) *conn {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &conn{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		auth:       auth,
+		httpClient: httpClient,
+	}
+}
+
+// request issues method/path with the given JSON body (nil for none),
+// applying auth and transparently re-authenticating once on a 401/403 when
+// running in AuthSession mode.
+func (c *conn) request(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	resp, err := c.doOnce(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.auth.Mode == AuthSession && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		resp.Body.Close()
+		if err := c.login(ctx); err != nil {
+			return nil, fmt.Errorf("session re-authentication failed: %w", err)
+		}
+		return c.doOnce(ctx, method, path, body)
+	}
+
+	return resp, nil
+}
+
+func (c *conn) doOnce(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := c.applyAuth(req); err != nil {
+		return nil, err
+	}
+
+	return c.httpClient.Do(req)
+}
+
+func (c *conn) applyAuth(req *http.Request) error {
+	switch c.auth.Mode {
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+c.auth.BearerToken)
+	case AuthSession:
+		c.mu.Lock()
+		cookie := c.cookie
+		c.mu.Unlock()
+		if cookie == nil {
+			if err := c.login(req.Context()); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			cookie = c.cookie
+			c.mu.Unlock()
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	default:
+		req.SetBasicAuth(c.auth.User, c.auth.Pass)
+	}
+	return nil
+}
+
+// login performs POST /control/login and caches the returned session
+// cookie for subsequent requests.
+func (c *conn) login(ctx context.Context) error {
+	payload, err := json.Marshal(struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}{Name: c.auth.User, Password: c.auth.Pass})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/control/login", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("login failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "agh_session" {
+			c.mu.Lock()
+			c.cookie = cookie
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	return fmt.Errorf("login succeeded but AdGuard returned no session cookie")
+}
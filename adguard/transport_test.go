@@ -0,0 +1,100 @@
+package adguard
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConn_BearerAuth(t *testing.T) {
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := newConn(server.URL, Auth{Mode: AuthBearer, BearerToken: "s3cr3t"}, nil)
+	if _, err := c.request(context.Background(), "GET", "/control/filtering/status", nil); err != nil {
+		t.Fatalf("request() returned error: %v", err)
+	}
+
+	if want := "Bearer s3cr3t"; gotAuth != want {
+		t.Errorf("Authorization header = %q, want %q", gotAuth, want)
+	}
+}
+
+func TestConn_SessionAuth_LoginsOnceAndReusesCookie(t *testing.T) {
+	logins := 0
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/control/login" {
+			logins++
+			http.SetCookie(w, &http.Cookie{Name: "agh_session", Value: "tok"})
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		requests++
+		cookie, err := r.Cookie("agh_session")
+		if err != nil || cookie.Value != "tok" {
+			t.Errorf("request %d missing session cookie: %v", requests, err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := newConn(server.URL, Auth{Mode: AuthSession, User: "admin", Pass: "hunter2"}, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := c.request(context.Background(), "GET", "/control/filtering/status", nil); err != nil {
+			t.Fatalf("request() returned error: %v", err)
+		}
+	}
+
+	if logins != 1 {
+		t.Errorf("logins = %d, want 1 (cookie should be cached)", logins)
+	}
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+}
+
+func TestConn_SessionAuth_ReauthenticatesOnExpiredCookie(t *testing.T) {
+	logins := 0
+	rejectedOnce := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/control/login" {
+			logins++
+			http.SetCookie(w, &http.Cookie{Name: "agh_session", Value: "tok"})
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		cookie, _ := r.Cookie("agh_session")
+		if !rejectedOnce {
+			rejectedOnce = true
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if cookie == nil || cookie.Value != "tok" {
+			t.Errorf("retried request missing refreshed session cookie")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := newConn(server.URL, Auth{Mode: AuthSession, User: "admin", Pass: "hunter2"}, nil)
+	resp, err := c.request(context.Background(), "GET", "/control/filtering/status", nil)
+	if err != nil {
+		t.Fatalf("request() returned error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %d, want 200 after re-authentication", resp.StatusCode)
+	}
+	if logins != 2 {
+		t.Errorf("logins = %d, want 2 (initial + re-auth after 401)", logins)
+	}
+}
@@ -1,33 +1,182 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"math/rand"
 	"net/http"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/will-white/adguard-external-dns-sidecar/adguard"
 )
 
 // Health check client mode flag
 var healthCheck = flag.Bool("health", false, "Run health check client and exit")
 
+// Single-shot mode flag, for Kubernetes Jobs / CI pipelines.
+var once = flag.Bool("once", false, "Run a single reconcile and exit")
+
+// maxBackoff caps the exponential backoff applied after repeated reconcile
+// failures, so a persistently unreachable AdGuard instance is polled at most
+// this infrequently.
+const maxBackoff = 5 * time.Minute
+
 type Config struct {
 	AdGuardURL    string
 	AdGuardUser   string
 	AdGuardPass   string
 	TargetRule    string
+	ManagedRules  []ManagedRule
+	Backend       string
 	CheckInterval time.Duration
 	HealthPort    string
+
+	AuthMode    adguard.AuthMode
+	BearerToken string
+
+	CAFile             string
+	InsecureSkipVerify bool
+	ClientCertFile     string
+	ClientKeyFile      string
+
+	// HTTPClient is built once by loadConfig, with TLS configured per the
+	// fields above, and reused for every request instead of being
+	// constructed per call.
+	HTTPClient *http.Client
+}
+
+// RuleStore is the backend-agnostic interface the reconcile loop drives.
+// Rules are backend-defined strings: raw AdGuard filter syntax for the
+// "adguard" backend, "<domain> <answer>" pairs for "adguard-rewrites".
+type RuleStore interface {
+	List(ctx context.Context) ([]string, error)
+	Replace(ctx context.Context, rules []string) error
+	Name() string
+
+	// Authoritative reports whether the sidecar owns the entire rule set
+	// for this backend. Authoritative backends (like "adguard-rewrites")
+	// reconcile to exactly the configured ManagedRules as an unordered
+	// set, with ManagedRules.Position ignored and stale entries deleted.
+	// Non-authoritative backends (like "adguard") reconcile via the
+	// position-based model in computeDesiredOrder, preserving any
+	// unmanaged user rules verbatim.
+	Authoritative() bool
+}
+
+// newRuleStore builds the RuleStore selected by config.Backend, using the
+// shared, TLS-configured config.HTTPClient built by loadConfig.
+func newRuleStore(config Config) (RuleStore, error) {
+	auth := adguard.Auth{
+		Mode:        config.AuthMode,
+		User:        config.AdGuardUser,
+		Pass:        config.AdGuardPass,
+		BearerToken: config.BearerToken,
+	}
+
+	switch config.Backend {
+	case "", "adguard":
+		return adguard.NewClient(config.AdGuardURL, auth, config.HTTPClient), nil
+	case "adguard-rewrites":
+		return adguard.NewRewritesClient(config.AdGuardURL, auth, config.HTTPClient), nil
+	default:
+		return nil, fmt.Errorf("unknown BACKEND %q", config.Backend)
+	}
+}
+
+// buildHTTPClient returns the single http.Client used for every request to
+// AdGuard, with TLS configured per config's ADGUARD_CA_FILE,
+// ADGUARD_INSECURE_SKIP_VERIFY, and ADGUARD_CLIENT_CERT/ADGUARD_CLIENT_KEY,
+// and apiRequestDuration instrumentation regardless of which RuleStore
+// backend is in use.
+func buildHTTPClient(config Config) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ADGUARD_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ADGUARD_CA_FILE does not contain a valid PEM certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ADGUARD_CLIENT_CERT/ADGUARD_CLIENT_KEY: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Clone DefaultTransport rather than starting from a bare
+	// http.Transport{}, so deployments fronting AdGuard with a reverse
+	// proxy keep working: HTTP(S)_PROXY/NO_PROXY and the other dialer/pool
+	// defaults are preserved, with only TLSClientConfig overridden.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: instrumentedTransport{base: transport},
+	}, nil
+}
+
+type instrumentedTransport struct {
+	base http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	apiRequestDuration.WithLabelValues(req.URL.Path, code).Observe(time.Since(start).Seconds())
+
+	return resp, err
 }
 
-type FilteringStatus struct {
-	UserRules []string `json:"user_rules"`
+// ManagedRule describes one external-dns-owned rule and where it must live
+// relative to the rest of the user rules list.
+//
+// Position accepts "top", "bottom" (the default), "before:<rule>",
+// "after:<rule>", or "index:N". If the referenced anchor rule is not found,
+// the rule falls back to "bottom". Position is ignored by Authoritative
+// backends, which reconcile ManagedRules as an unordered set.
+//
+// Owned marks the rule as exclusively managed by the sidecar: unowned rules
+// are never touched and are preserved verbatim in their original relative
+// order. Owned is likewise ignored by Authoritative backends, which own
+// every configured rule by definition.
+type ManagedRule struct {
+	Rule     string `json:"rule"`
+	Position string `json:"position"`
+	Owned    bool   `json:"owned"`
 }
 
 // Health status for the health check endpoint
@@ -36,6 +185,71 @@ var (
 	lastCheckOK = true
 )
 
+// logger is the process-wide structured logger. Its format and level are
+// controlled by LOG_FORMAT (text, the default, or json) and LOG_LEVEL
+// (debug, info, the default, warn, or error).
+var logger = newLogger()
+
+// reconcileCounter assigns each reconcile loop a monotonically increasing id
+// for correlating its log lines.
+var reconcileCounter atomic.Uint64
+
+func newLogger() *slog.Logger {
+	level := slog.LevelInfo
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.ToLower(os.Getenv("LOG_FORMAT")) == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+
+	return slog.New(handler).With("component", "adguard-sidecar")
+}
+
+// Prometheus metrics exposed on /metrics alongside the health server.
+var (
+	reconcileTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "adguard_sidecar_reconcile_total",
+		Help: "Total number of reconcile attempts, by result.",
+	}, []string{"result"})
+
+	reconcileDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "adguard_sidecar_reconcile_duration_seconds",
+		Help: "Time spent performing a full reconcile loop.",
+	})
+
+	ruleMovesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "adguard_sidecar_rule_moves_total",
+		Help: "Total number of managed rules moved to a new position (for authoritative backends, rules added or deleted).",
+	})
+
+	userRulesCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "adguard_sidecar_user_rules_count",
+		Help: "Number of user rules currently reported by AdGuard.",
+	})
+
+	lastSuccessTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "adguard_sidecar_last_success_timestamp_seconds",
+		Help: "Unix timestamp of the last successful reconcile.",
+	})
+
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "adguard_sidecar_api_request_duration_seconds",
+		Help: "Latency of requests made to the AdGuard API, by endpoint and status code.",
+	}, []string{"endpoint", "code"})
+)
+
 func main() {
 	flag.Parse()
 
@@ -56,39 +270,33 @@ func main() {
 		os.Exit(0)
 	}
 
-	log.Println("Starting AdGuard External-DNS Sidecar...")
+	logger.Info("Starting AdGuard External-DNS Sidecar...")
 
 	config := loadConfig()
-	log.Printf("Configuration loaded: URL=%s, Target Rule=%s, Check Interval=%v",
-		config.AdGuardURL, config.TargetRule, config.CheckInterval)
-
-	// Start health check server
-	go startHealthServer(config.HealthPort)
-
-	// Run the main loop
-	ticker := time.NewTicker(config.CheckInterval)
-	defer ticker.Stop()
+	logger.Info("Configuration loaded",
+		"adguard_url", config.AdGuardURL, "rule_count", len(config.ManagedRules), "check_interval", config.CheckInterval)
 
-	// Run immediately on startup
-	if err := enforceRulePosition(config); err != nil {
-		log.Printf("Error on initial check: %v", err)
-		lastCheckOK = false
-	} else {
-		lastCheckOK = true
+	reconciler, err := NewReconciler(config)
+	if err != nil {
+		logger.Error("Failed to build RuleStore", "error", err)
+		os.Exit(1)
 	}
 
-	// Then run on interval
-	for range ticker.C {
-		if err := enforceRulePosition(config); err != nil {
-			log.Printf("Error enforcing rule position: %v", err)
-			lastCheckOK = false
-		} else {
-			lastCheckOK = true
+	if *once {
+		if err := reconciler.Reconcile(); err != nil {
+			logger.Error("Reconcile failed", "error", err)
+			os.Exit(1)
 		}
+		return
 	}
+
+	// Start health check server
+	go startHealthServer(config.HealthPort, reconciler)
+
+	reconciler.Run(context.Background())
 }
 
-func startHealthServer(port string) {
+func startHealthServer(port string, reconciler *Reconciler) {
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		if healthy && lastCheckOK {
 			w.WriteHeader(http.StatusOK)
@@ -104,19 +312,134 @@ func startHealthServer(port string) {
 		w.Write([]byte("READY"))
 	})
 
-	log.Printf("Health server listening on port %s", port)
+	// /reconcile lets external-dns post-hooks or webhooks nudge an immediate
+	// run instead of waiting out CheckInterval.
+	http.HandleFunc("/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reconciler.Reconcile(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	http.Handle("/metrics", promhttp.Handler())
+
+	logger.Info("Health server listening", "port", port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Printf("Health server error: %v", err)
+		logger.Error("Health server error", "error", err)
 		healthy = false
 	}
 }
 
+// Reconciler drives the reconcile loop: a CheckInterval tick as a safety
+// net, on-demand runs triggered via /reconcile, and exponential backoff with
+// jitter after repeated AdGuard API failures so a down AdGuard instance
+// isn't hammered every tick.
+type Reconciler struct {
+	config Config
+	store  RuleStore
+
+	mu       sync.Mutex
+	failures int
+}
+
+// NewReconciler returns a Reconciler ready to drive config's reconcile loop,
+// backed by the RuleStore selected via config.Backend.
+func NewReconciler(config Config) (*Reconciler, error) {
+	store, err := newRuleStore(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Reconciler{config: config, store: store}, nil
+}
+
+// Reconcile runs a single reconcile pass. It is safe to call concurrently
+// from both the Run loop and the /reconcile handler; calls are serialized so
+// the backend never sees overlapping reads and writes.
+func (r *Reconciler) Reconcile() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.enforceRulePosition()
+	if err != nil {
+		r.failures++
+		lastCheckOK = false
+	} else {
+		r.failures = 0
+		lastCheckOK = true
+	}
+	return err
+}
+
+// Run performs an initial reconcile and then loops, waking on CheckInterval
+// or whenever backoff expires, until ctx is canceled.
+func (r *Reconciler) Run(ctx context.Context) {
+	r.Reconcile()
+
+	for {
+		timer := time.NewTimer(r.nextDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			r.Reconcile()
+		}
+	}
+}
+
+// nextDelay returns CheckInterval when the last reconcile succeeded, or an
+// exponentially increasing, jittered delay capped at maxBackoff after
+// consecutive failures.
+func (r *Reconciler) nextDelay() time.Duration {
+	r.mu.Lock()
+	failures := r.failures
+	r.mu.Unlock()
+
+	if failures == 0 {
+		return r.config.CheckInterval
+	}
+
+	backoff := r.config.CheckInterval
+	for i := 0; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	// Equal jitter: wait somewhere between backoff/2 and backoff.
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
 func loadConfig() Config {
 	config := Config{
 		AdGuardURL:  getEnvOrFatal("ADGUARD_URL"),
 		AdGuardUser: getEnvOrFatal("ADGUARD_USER"),
 		AdGuardPass: getEnvOrFatal("ADGUARD_PASS"),
-		TargetRule:  getEnvOrFatal("TARGET_RULE"),
+	}
+
+	managedRules, err := loadManagedRules()
+	if err != nil {
+		logger.Error("Failed to load TARGET_RULES", "error", err)
+		os.Exit(1)
+	}
+	if managedRules != nil {
+		config.ManagedRules = managedRules
+	} else {
+		// Fall back to the legacy single-rule behavior: one owned rule,
+		// pinned to the bottom of the list.
+		config.TargetRule = getEnvOrFatal("TARGET_RULE")
+		config.ManagedRules = []ManagedRule{
+			{Rule: config.TargetRule, Position: "bottom", Owned: true},
+		}
 	}
 
 	// Parse CHECK_INTERVAL with default
@@ -126,10 +449,12 @@ func loadConfig() Config {
 	} else {
 		seconds, err := strconv.Atoi(intervalStr)
 		if err != nil {
-			log.Fatalf("CHECK_INTERVAL must be a valid integer (seconds): %v", err)
+			logger.Error("CHECK_INTERVAL must be a valid integer (seconds)", "error", err)
+			os.Exit(1)
 		}
 		if seconds <= 0 {
-			log.Fatal("CHECK_INTERVAL must be greater than 0")
+			logger.Error("CHECK_INTERVAL must be greater than 0")
+			os.Exit(1)
 		}
 		config.CheckInterval = time.Duration(seconds) * time.Second
 	}
@@ -140,132 +465,334 @@ func loadConfig() Config {
 		config.HealthPort = "8080"
 	}
 
+	// BACKEND selects the RuleStore implementation; defaults to "adguard".
+	config.Backend = os.Getenv("BACKEND")
+
 	// Ensure URL doesn't end with slash
 	config.AdGuardURL = strings.TrimSuffix(config.AdGuardURL, "/")
 
+	// ADGUARD_AUTH selects how requests authenticate; defaults to "basic".
+	switch mode := adguard.AuthMode(strings.ToLower(os.Getenv("ADGUARD_AUTH"))); mode {
+	case "":
+		config.AuthMode = adguard.AuthBasic
+	case adguard.AuthBasic, adguard.AuthBearer, adguard.AuthSession:
+		config.AuthMode = mode
+	default:
+		logger.Error("ADGUARD_AUTH must be one of basic, bearer, session", "value", mode)
+		os.Exit(1)
+	}
+	if config.AuthMode == adguard.AuthBearer {
+		config.BearerToken = getEnvOrFatal("ADGUARD_BEARER_TOKEN")
+	}
+
+	config.CAFile = os.Getenv("ADGUARD_CA_FILE")
+	config.InsecureSkipVerify = os.Getenv("ADGUARD_INSECURE_SKIP_VERIFY") == "true"
+	config.ClientCertFile = os.Getenv("ADGUARD_CLIENT_CERT")
+	config.ClientKeyFile = os.Getenv("ADGUARD_CLIENT_KEY")
+
+	httpClient, err := buildHTTPClient(config)
+	if err != nil {
+		logger.Error("Failed to configure AdGuard HTTP client", "error", err)
+		os.Exit(1)
+	}
+	config.HTTPClient = httpClient
+
 	return config
 }
 
 func getEnvOrFatal(key string) string {
 	value := os.Getenv(key)
 	if value == "" {
-		log.Fatalf("Required environment variable %s is not set", key)
+		logger.Error("Required environment variable is not set", "key", key)
+		os.Exit(1)
 	}
 	return value
 }
 
-func enforceRulePosition(config Config) error {
+// loadManagedRules reads the TARGET_RULES config, either inline as JSON in
+// the env var itself or from a file referenced by TARGET_RULES_FILE. It
+// returns (nil, nil) when neither is set, signaling the caller to fall back
+// to the legacy single TARGET_RULE behavior.
+func loadManagedRules() ([]ManagedRule, error) {
+	raw := os.Getenv("TARGET_RULES")
+	if raw == "" {
+		path := os.Getenv("TARGET_RULES_FILE")
+		if path == "" {
+			return nil, nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TARGET_RULES_FILE: %w", err)
+		}
+		raw = string(data)
+	}
+
+	var managedRules []ManagedRule
+	if err := json.Unmarshal([]byte(raw), &managedRules); err != nil {
+		return nil, fmt.Errorf("failed to parse TARGET_RULES as JSON: %w", err)
+	}
+	if len(managedRules) == 0 {
+		return nil, fmt.Errorf("TARGET_RULES must contain at least one rule")
+	}
+	for i, mr := range managedRules {
+		if mr.Rule == "" {
+			return nil, fmt.Errorf("TARGET_RULES[%d] is missing \"rule\"", i)
+		}
+		if mr.Position == "" {
+			managedRules[i].Position = "bottom"
+		}
+	}
+	return managedRules, nil
+}
+
+func (r *Reconciler) enforceRulePosition() (err error) {
+	config := r.config
+	ctx := context.Background()
+
+	start := time.Now()
+	id := reconcileCounter.Add(1)
+	rlog := logger.With("reconcile_id", id, "adguard_url", config.AdGuardURL, "backend", r.store.Name())
+
+	defer func() {
+		duration := time.Since(start)
+		reconcileDuration.Observe(duration.Seconds())
+		if err != nil {
+			reconcileTotal.WithLabelValues("error").Inc()
+			rlog.Error("Reconcile failed", "duration_ms", duration.Milliseconds(), "error", err)
+		} else {
+			reconcileTotal.WithLabelValues("ok").Inc()
+			lastSuccessTimestamp.SetToCurrentTime()
+			rlog.Debug("Reconcile finished", "duration_ms", duration.Milliseconds())
+		}
+	}()
+
 	// Fetch current rules
-	rules, err := fetchUserRules(config)
+	rules, err := r.store.List(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to fetch rules: %w", err)
+		return fmt.Errorf("failed to list rules: %w", err)
 	}
 
-	log.Printf("Fetched %d user rules from AdGuard", len(rules))
+	rlog.Debug("Fetched rules from backend", "rule_count", len(rules))
+	userRulesCount.Set(float64(len(rules)))
+	rlog.Debug("Computed rules hash", "rules_hash", rulesHash(rules))
+
+	// Compute the desired set of rules and diff it against the current
+	// list; only issue a Replace call if it differs. Authoritative
+	// backends (e.g. "adguard-rewrites") reconcile to exactly the
+	// configured ManagedRules as a set; others reconcile via the
+	// position-based model, preserving unmanaged user rules verbatim.
+	authoritative := r.store.Authoritative()
+
+	var desiredRules []string
+	if authoritative {
+		desiredRules = authoritativeRuleSet(config.ManagedRules)
+	} else {
+		desiredRules = computeDesiredOrder(rules, config.ManagedRules)
+	}
 
-	// Check if target rule is at the bottom
-	if isRuleAtBottom(rules, config.TargetRule) {
-		log.Println("Target rule is already at the bottom. No action needed.")
+	if rulesEqual(authoritative, rules, desiredRules) {
+		rlog.Debug("All managed rules are already in position. No action needed.")
 		return nil
 	}
 
-	// Remove all occurrences of the target rule and append it to the end
-	updatedRules := removeRule(rules, config.TargetRule)
-	updatedRules = append(updatedRules, config.TargetRule)
+	changed := countChangedRules(authoritative, rules, desiredRules, config.ManagedRules)
+	rlog.Info("Reordering managed rules", "rule_count", len(desiredRules), "rules_moved", changed)
 
-	log.Printf("Moving target rule to bottom position (rule %d of %d)", len(updatedRules), len(updatedRules))
+	// Re-list immediately before writing to guard against a TOCTOU race with
+	// someone editing rules directly between our initial fetch and this
+	// write. If the pre-image has changed, bail out and let the next
+	// reconcile pick up the new state instead of clobbering it.
+	preImage, err := r.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to verify rules before update: %w", err)
+	}
+	if !rulesEqual(authoritative, preImage, rules) {
+		rlog.Warn("Rules changed since initial fetch, aborting this reconcile to avoid clobbering a concurrent edit",
+			"pre_fetch_hash", rulesHash(rules), "pre_update_hash", rulesHash(preImage))
+		return nil
+	}
 
-	// Update rules in AdGuard
-	if err := updateUserRules(config, updatedRules); err != nil {
+	// Update rules via the backend
+	if err := r.store.Replace(ctx, desiredRules); err != nil {
 		return fmt.Errorf("failed to update rules: %w", err)
 	}
 
-	log.Println("Successfully updated user rules in AdGuard")
+	ruleMovesTotal.Add(float64(changed))
+	rlog.Info("Successfully updated rules via backend", "rule_count", len(desiredRules))
 	return nil
 }
 
-func isRuleAtBottom(rules []string, targetRule string) bool {
-	if len(rules) == 0 {
-		return false
-	}
-	// Check if the last rule matches the target
-	return rules[len(rules)-1] == targetRule
+// rulesHash returns a hex-encoded SHA-256 digest of rules, order-independent,
+// so operators can correlate reconcile log lines with unexpected changes
+// made outside the sidecar.
+func rulesHash(rules []string) string {
+	sorted := append([]string(nil), rules...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
 }
 
-func removeRule(rules []string, targetRule string) []string {
-	var result []string
-	for _, rule := range rules {
-		if rule != targetRule {
-			result = append(result, rule)
-		}
+// authoritativeRuleSet returns the full configured rule set for an
+// Authoritative backend, ignoring Position and Owned, which only apply to
+// the position-based model.
+func authoritativeRuleSet(managedRules []ManagedRule) []string {
+	rules := make([]string, len(managedRules))
+	for i, mr := range managedRules {
+		rules[i] = mr.Rule
 	}
-	return result
+	return rules
 }
 
-func fetchUserRules(config Config) ([]string, error) {
-	url := fmt.Sprintf("%s/control/filtering/status", config.AdGuardURL)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// rulesEqual compares a and b the way the backend expects: as an
+// order-independent set for Authoritative backends, or exactly (order
+// included) otherwise.
+func rulesEqual(authoritative bool, a, b []string) bool {
+	if authoritative {
+		return symmetricDiffCount(a, b) == 0
 	}
+	return reflect.DeepEqual(a, b)
+}
 
-	req.SetBasicAuth(config.AdGuardUser, config.AdGuardPass)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// countChangedRules returns how many rules changed between original and
+// desired: a set-difference count for Authoritative backends, or the
+// number of managedRules that changed index otherwise.
+func countChangedRules(authoritative bool, original, desired []string, managedRules []ManagedRule) int {
+	if authoritative {
+		return symmetricDiffCount(original, desired)
 	}
-	defer resp.Body.Close()
+	return countMovedRules(original, desired, managedRules)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+// symmetricDiffCount returns how many entries differ between a and b when
+// both are treated as multisets, e.g. the number of rules that would need
+// to be added or deleted to turn a into b.
+func symmetricDiffCount(a, b []string) int {
+	counts := make(map[string]int, len(a)+len(b))
+	for _, rule := range a {
+		counts[rule]++
 	}
-
-	var status FilteringStatus
-	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
-		return nil, err
+	for _, rule := range b {
+		counts[rule]--
 	}
 
-	return status.UserRules, nil
+	diff := 0
+	for _, c := range counts {
+		if c < 0 {
+			c = -c
+		}
+		diff += c
+	}
+	return diff
 }
 
-func updateUserRules(config Config, rules []string) error {
-	url := fmt.Sprintf("%s/control/filtering/set_rules", config.AdGuardURL)
+// countMovedRules returns how many managedRules changed index between
+// original and desired.
+func countMovedRules(original, desired []string, managedRules []ManagedRule) int {
+	moved := 0
+	for _, mr := range managedRules {
+		if indexOfRule(original, mr.Rule) != indexOfRule(desired, mr.Rule) {
+			moved++
+		}
+	}
+	return moved
+}
 
-	// The API expects JSON with the rules array
-	payload := struct {
-		Rules []string `json:"rules"`
-	}{
-		Rules: rules,
+// computeDesiredOrder returns the stable target ordering for current once
+// every managed rule has been placed according to its position policy.
+// Unowned rules, and any user-authored rules not referenced by managedRules,
+// are preserved verbatim in their original relative order.
+func computeDesiredOrder(current []string, managedRules []ManagedRule) []string {
+	owned := make(map[string]bool, len(managedRules))
+	for _, mr := range managedRules {
+		if mr.Owned {
+			owned[mr.Rule] = true
+		}
 	}
 
-	jsonBody, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal rules: %w", err)
+	base := make([]string, 0, len(current))
+	for _, rule := range current {
+		if !owned[rule] {
+			base = append(base, rule)
+		}
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return err
+	for _, mr := range managedRules {
+		if !mr.Owned {
+			// Unowned rules are left exactly where they already are; only
+			// owned rules are actively repositioned.
+			continue
+		}
+		base = placeManagedRule(base, mr)
 	}
 
-	req.SetBasicAuth(config.AdGuardUser, config.AdGuardPass)
-	req.Header.Set("Content-Type", "application/json")
+	return base
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+// placeManagedRule removes any existing occurrence of mr.Rule from rules and
+// re-inserts it at the position described by mr.Position.
+func placeManagedRule(rules []string, mr ManagedRule) []string {
+	filtered := removeRule(rules, mr.Rule)
+
+	switch {
+	case mr.Position == "top":
+		return append([]string{mr.Rule}, filtered...)
+	case mr.Position == "" || mr.Position == "bottom":
+		return append(filtered, mr.Rule)
+	case strings.HasPrefix(mr.Position, "before:"):
+		anchor := strings.TrimPrefix(mr.Position, "before:")
+		if idx := indexOfRule(filtered, anchor); idx != -1 {
+			return insertRuleAt(filtered, mr.Rule, idx)
+		}
+		return append(filtered, mr.Rule)
+	case strings.HasPrefix(mr.Position, "after:"):
+		anchor := strings.TrimPrefix(mr.Position, "after:")
+		if idx := indexOfRule(filtered, anchor); idx != -1 {
+			return insertRuleAt(filtered, mr.Rule, idx+1)
+		}
+		return append(filtered, mr.Rule)
+	case strings.HasPrefix(mr.Position, "index:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(mr.Position, "index:"))
+		if err != nil {
+			return append(filtered, mr.Rule)
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n > len(filtered) {
+			n = len(filtered)
+		}
+		return insertRuleAt(filtered, mr.Rule, n)
+	default:
+		return append(filtered, mr.Rule)
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+// indexOfRule returns the index of rule in rules, or -1 if not present.
+func indexOfRule(rules []string, rule string) int {
+	for i, r := range rules {
+		if r == rule {
+			return i
+		}
 	}
+	return -1
+}
 
-	return nil
+// insertRuleAt inserts rule into rules at index idx, shifting later rules
+// down by one.
+func insertRuleAt(rules []string, rule string, idx int) []string {
+	result := make([]string, 0, len(rules)+1)
+	result = append(result, rules[:idx]...)
+	result = append(result, rule)
+	result = append(result, rules[idx:]...)
+	return result
+}
+
+func removeRule(rules []string, targetRule string) []string {
+	var result []string
+	for _, rule := range rules {
+		if rule != targetRule {
+			result = append(result, rule)
+		}
+	}
+	return result
 }
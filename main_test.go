@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -10,48 +9,6 @@ import (
 	"time"
 )
 
-func TestIsRuleAtBottom(t *testing.T) {
-	tests := []struct {
-		name       string
-		rules      []string
-		targetRule string
-		want       bool
-	}{
-		{
-			name:       "Rule at bottom",
-			rules:      []string{"rule1", "rule2", "target"},
-			targetRule: "target",
-			want:       true,
-		},
-		{
-			name:       "Rule not at bottom",
-			rules:      []string{"rule1", "target", "rule2"},
-			targetRule: "target",
-			want:       false,
-		},
-		{
-			name:       "Rule missing",
-			rules:      []string{"rule1", "rule2"},
-			targetRule: "target",
-			want:       false,
-		},
-		{
-			name:       "Empty rules",
-			rules:      []string{},
-			targetRule: "target",
-			want:       false,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := isRuleAtBottom(tt.rules, tt.targetRule); got != tt.want {
-				t.Errorf("isRuleAtBottom() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestRemoveRule(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -101,58 +58,208 @@ func TestRemoveRule(t *testing.T) {
 	}
 }
 
-func TestUpdateUserRules_ContentTypeHeader(t *testing.T) {
-	var receivedContentType string
-	var receivedBody []byte
+func TestComputeDesiredOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		current      []string
+		managedRules []ManagedRule
+		want         []string
+	}{
+		{
+			name:    "single rule pinned to bottom",
+			current: []string{"user1", "target", "user2"},
+			managedRules: []ManagedRule{
+				{Rule: "target", Position: "bottom", Owned: true},
+			},
+			want: []string{"user1", "user2", "target"},
+		},
+		{
+			name:    "rule pinned to top",
+			current: []string{"user1", "user2"},
+			managedRules: []ManagedRule{
+				{Rule: "allowlist", Position: "top", Owned: true},
+			},
+			want: []string{"allowlist", "user1", "user2"},
+		},
+		{
+			name:    "before and after anchors",
+			current: []string{"user1", "anchor", "user2"},
+			managedRules: []ManagedRule{
+				{Rule: "blocklist", Position: "before:anchor", Owned: true},
+				{Rule: "allowlist", Position: "after:anchor", Owned: true},
+			},
+			want: []string{"user1", "blocklist", "anchor", "allowlist", "user2"},
+		},
+		{
+			name:    "missing anchor falls back to bottom",
+			current: []string{"user1"},
+			managedRules: []ManagedRule{
+				{Rule: "rule1", Position: "before:nope", Owned: true},
+			},
+			want: []string{"user1", "rule1"},
+		},
+		{
+			name:    "absolute index",
+			current: []string{"user1", "user2"},
+			managedRules: []ManagedRule{
+				{Rule: "rule1", Position: "index:1", Owned: true},
+			},
+			want: []string{"user1", "rule1", "user2"},
+		},
+		{
+			name:    "unowned user rules are preserved",
+			current: []string{"user1", "target"},
+			managedRules: []ManagedRule{
+				{Rule: "target", Position: "bottom", Owned: false},
+			},
+			want: []string{"user1", "target"},
+		},
+		{
+			name:    "unowned rule out of position is left alone, not moved",
+			current: []string{"user1", "target"},
+			managedRules: []ManagedRule{
+				{Rule: "target", Position: "top", Owned: false},
+			},
+			want: []string{"user1", "target"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeDesiredOrder(tt.current, tt.managedRules)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeDesiredOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRulesHash(t *testing.T) {
+	a := rulesHash([]string{"rule1", "rule2"})
+	b := rulesHash([]string{"rule2", "rule1"})
+	if a != b {
+		t.Errorf("rulesHash() should be order-independent, got %q and %q", a, b)
+	}
 
-	// Create a test server that captures the Content-Type header
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		receivedContentType = r.Header.Get("Content-Type")
-		receivedBody, _ = io.ReadAll(r.Body)
+	c := rulesHash([]string{"rule1", "rule3"})
+	if a == c {
+		t.Errorf("rulesHash() of different rule sets should differ, both got %q", a)
+	}
+}
 
-		if r.URL.Path == "/control/filtering/set_rules" {
-			if receivedContentType != "application/json" {
-				w.WriteHeader(415)
-				w.Write([]byte("only content-type application/json is allowed"))
-				return
+func TestEnforceRulePosition_AbortsOnConcurrentEdit(t *testing.T) {
+	getCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/control/filtering/status":
+			getCount++
+			rules := []string{"user1", "target"}
+			if getCount == 2 {
+				// Simulate a concurrent edit landing between our initial
+				// GET and the pre-update re-check.
+				rules = []string{"user1", "user2", "target"}
 			}
+			json.NewEncoder(w).Encode(struct {
+				UserRules []string `json:"user_rules"`
+			}{UserRules: rules})
+		case "/control/filtering/set_rules":
+			t.Error("set_rules should not be called when the pre-image has changed")
 			w.WriteHeader(http.StatusOK)
 		}
 	}))
 	defer server.Close()
 
 	config := Config{
-		AdGuardURL:    server.URL,
-		AdGuardUser:   "testuser",
-		AdGuardPass:   "testpass",
-		TargetRule:    "testrule",
-		CheckInterval: 60 * time.Second,
-		HealthPort:    "8080",
+		AdGuardURL: server.URL,
+		ManagedRules: []ManagedRule{
+			{Rule: "target", Position: "top", Owned: true},
+		},
 	}
 
-	rules := []string{"rule1", "rule2", "rule3"}
-	err := updateUserRules(config, rules)
-
+	reconciler, err := NewReconciler(config)
 	if err != nil {
-		t.Errorf("updateUserRules() returned error: %v", err)
+		t.Fatalf("NewReconciler() returned error: %v", err)
+	}
+	if err := reconciler.enforceRulePosition(); err != nil {
+		t.Errorf("enforceRulePosition() returned error: %v", err)
+	}
+	if getCount != 2 {
+		t.Errorf("expected 2 GET requests (initial fetch + pre-update check), got %d", getCount)
 	}
+}
+
+func TestEnforceRulePosition_AuthoritativeBackendDeletesStaleRules(t *testing.T) {
+	var adds, deletes []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/control/rewrite/list":
+			json.NewEncoder(w).Encode([]struct {
+				Domain string `json:"domain"`
+				Answer string `json:"answer"`
+			}{
+				{Domain: "keep.example.com", Answer: "10.0.0.2"},
+				{Domain: "stale.example.com", Answer: "10.0.0.9"},
+			})
+		case "/control/rewrite/add":
+			var e struct {
+				Domain string `json:"domain"`
+				Answer string `json:"answer"`
+			}
+			json.NewDecoder(r.Body).Decode(&e)
+			adds = append(adds, e.Domain+" "+e.Answer)
+			w.WriteHeader(http.StatusOK)
+		case "/control/rewrite/delete":
+			var e struct {
+				Domain string `json:"domain"`
+				Answer string `json:"answer"`
+			}
+			json.NewDecoder(r.Body).Decode(&e)
+			deletes = append(deletes, e.Domain+" "+e.Answer)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
 
-	if receivedContentType != "application/json" {
-		t.Errorf("Content-Type header = '%s', want 'application/json'", receivedContentType)
+	config := Config{
+		AdGuardURL: server.URL,
+		Backend:    "adguard-rewrites",
+		ManagedRules: []ManagedRule{
+			{Rule: "keep.example.com 10.0.0.2", Owned: true},
+			{Rule: "new.example.com 10.0.0.3", Owned: true},
+		},
 	}
 
-	// Verify the body is valid JSON with the expected structure
-	var payload struct {
-		Rules []string `json:"rules"`
+	reconciler, err := NewReconciler(config)
+	if err != nil {
+		t.Fatalf("NewReconciler() returned error: %v", err)
 	}
-	if err := json.Unmarshal(receivedBody, &payload); err != nil {
-		t.Errorf("Failed to unmarshal request body: %v", err)
+	if err := reconciler.enforceRulePosition(); err != nil {
+		t.Errorf("enforceRulePosition() returned error: %v", err)
 	}
 
-	if !reflect.DeepEqual(payload.Rules, rules) {
-		t.Errorf("Request body rules = %v, want %v", payload.Rules, rules)
+	if !reflect.DeepEqual(deletes, []string{"stale.example.com 10.0.0.9"}) {
+		t.Errorf("deletes = %v, want [stale.example.com 10.0.0.9] — stale rewrites must be removed", deletes)
 	}
+	if !reflect.DeepEqual(adds, []string{"new.example.com 10.0.0.3"}) {
+		t.Errorf("adds = %v, want [new.example.com 10.0.0.3]", adds)
+	}
+}
+
+func TestReconcilerNextDelay(t *testing.T) {
+	r := &Reconciler{config: Config{CheckInterval: 10 * time.Second}}
 
-	t.Logf("✓ Content-Type header correctly set to: %s", receivedContentType)
-	t.Logf("✓ Request body: %s", string(receivedBody))
+	if got := r.nextDelay(); got != 10*time.Second {
+		t.Errorf("nextDelay() with no failures = %v, want %v", got, 10*time.Second)
+	}
+
+	r.failures = 1
+	if got := r.nextDelay(); got < 10*time.Second || got > 20*time.Second {
+		t.Errorf("nextDelay() after 1 failure = %v, want between 10s and 20s", got)
+	}
+
+	r.failures = 20
+	if got := r.nextDelay(); got < maxBackoff/2 || got > maxBackoff {
+		t.Errorf("nextDelay() after many failures = %v, want between %v and %v", got, maxBackoff/2, maxBackoff)
+	}
 }